@@ -0,0 +1,144 @@
+package scanblock
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultTarpitSeconds is used when a "tarpit:" block action is configured
+// without an explicit duration.
+const DefaultTarpitSeconds = 30
+
+type blockActionKind int
+
+const (
+	blockActionDrop blockActionKind = iota
+	blockActionStatus
+	blockActionTarpit
+	blockActionRedirect
+)
+
+// blockAction describes how a blocked request should be answered.
+type blockAction struct {
+	kind     blockActionKind
+	status   int
+	duration time.Duration
+	url      string
+}
+
+// parseBlockAction parses Config.BlockAction into a blockAction. An empty
+// string means "drop", mirroring the plugin's original hijack-and-close
+// behavior.
+func parseBlockAction(raw string) (blockAction, error) {
+	switch {
+	case raw == "" || raw == "drop":
+		return blockAction{kind: blockActionDrop}, nil
+	case strings.HasPrefix(raw, "status:"):
+		code, err := strconv.Atoi(strings.TrimPrefix(raw, "status:"))
+		if err != nil {
+			return blockAction{}, fmt.Errorf("invalid status block action %q: %w", raw, err)
+		}
+		return blockAction{kind: blockActionStatus, status: code}, nil
+	case strings.HasPrefix(raw, "tarpit:"):
+		suffix := strings.TrimPrefix(raw, "tarpit:")
+		if suffix == "" {
+			return blockAction{kind: blockActionTarpit, duration: DefaultTarpitSeconds * time.Second}, nil
+		}
+		duration, err := time.ParseDuration(suffix)
+		if err != nil {
+			return blockAction{}, fmt.Errorf("invalid tarpit block action %q: %w", raw, err)
+		}
+		return blockAction{kind: blockActionTarpit, duration: duration}, nil
+	case strings.HasPrefix(raw, "redirect:"):
+		url := strings.TrimPrefix(raw, "redirect:")
+		if url == "" {
+			return blockAction{}, fmt.Errorf("redirect block action requires a URL, got %q", raw)
+		}
+		return blockAction{kind: blockActionRedirect, url: url}, nil
+	default:
+		return blockAction{}, fmt.Errorf("unknown block action %q", raw)
+	}
+}
+
+// randomBlockAction picks a random response, used when Config.PlayGames is
+// set.
+func randomBlockAction() blockAction {
+	switch rand.Intn(3) {
+	case 0:
+		return blockAction{kind: blockActionDrop}
+	case 1:
+		codes := []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusTeapot, http.StatusTooManyRequests}
+		return blockAction{kind: blockActionStatus, status: codes[rand.Intn(len(codes))]}
+	default:
+		return blockAction{kind: blockActionTarpit, duration: time.Duration(2+rand.Intn(5)) * time.Second}
+	}
+}
+
+// block serves a response to a request that scanblock has decided to block,
+// per the configured (or randomized, if PlayGames is set) block action.
+func (sb *ScanBlock) block(w http.ResponseWriter, r *http.Request) {
+	action := sb.blockAction
+	if sb.config.PlayGames {
+		action = randomBlockAction()
+	}
+
+	sb.metrics.RecordBlockAction(action.kind)
+
+	switch action.kind {
+	case blockActionStatus:
+		w.WriteHeader(action.status)
+	case blockActionTarpit:
+		tarpit(w, action.duration)
+	case blockActionRedirect:
+		http.Redirect(w, r, action.url, http.StatusFound)
+	default:
+		dropConnection(w)
+	}
+}
+
+// dropConnection hijacks the underlying connection and closes it without
+// writing a response, so the scanner gets no usable signal at all.
+func dropConnection(w http.ResponseWriter) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	conn, _, err := hijacker.Hijack()
+	if err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	conn.Close()
+}
+
+// tarpit slow-drips a byte at a time for duration before letting the
+// response complete, to waste the scanner's time. It drips once per second
+// for the default multi-second durations, but ticks faster for a duration
+// configured below a second (e.g. "tarpit:200ms") so that's still honored
+// rather than rounded up to a full second.
+func tarpit(w http.ResponseWriter, duration time.Duration) {
+	flusher, _ := w.(http.Flusher)
+
+	tick := duration
+	if tick <= 0 || tick > time.Second {
+		tick = time.Second
+	}
+
+	deadline := time.Now().Add(duration)
+	for time.Now().Before(deadline) {
+		if _, err := w.Write([]byte{0}); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		time.Sleep(tick)
+	}
+}
@@ -0,0 +1,80 @@
+package scanblock
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseBlockAction(t *testing.T) {
+	cases := []struct {
+		raw     string
+		wantErr bool
+		check   func(t *testing.T, a blockAction)
+	}{
+		{raw: "", check: func(t *testing.T, a blockAction) {
+			if a.kind != blockActionDrop {
+				t.Errorf("kind = %v, want blockActionDrop", a.kind)
+			}
+		}},
+		{raw: "drop", check: func(t *testing.T, a blockAction) {
+			if a.kind != blockActionDrop {
+				t.Errorf("kind = %v, want blockActionDrop", a.kind)
+			}
+		}},
+		{raw: "status:403", check: func(t *testing.T, a blockAction) {
+			if a.kind != blockActionStatus || a.status != 403 {
+				t.Errorf("got %+v, want status 403", a)
+			}
+		}},
+		{raw: "status:nope", wantErr: true},
+		{raw: "tarpit:", check: func(t *testing.T, a blockAction) {
+			if a.kind != blockActionTarpit || a.duration != DefaultTarpitSeconds*time.Second {
+				t.Errorf("got %+v, want default tarpit duration", a)
+			}
+		}},
+		{raw: "tarpit:5s", check: func(t *testing.T, a blockAction) {
+			if a.kind != blockActionTarpit || a.duration != 5*time.Second {
+				t.Errorf("got %+v, want 5s tarpit", a)
+			}
+		}},
+		{raw: "tarpit:nope", wantErr: true},
+		{raw: "redirect:https://example.com/blocked", check: func(t *testing.T, a blockAction) {
+			if a.kind != blockActionRedirect || a.url != "https://example.com/blocked" {
+				t.Errorf("got %+v, want redirect URL", a)
+			}
+		}},
+		{raw: "redirect:", wantErr: true},
+		{raw: "bogus", wantErr: true},
+	}
+
+	for _, c := range cases {
+		action, err := parseBlockAction(c.raw)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("parseBlockAction(%q) = nil error, want error", c.raw)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseBlockAction(%q) = %v, want no error", c.raw, err)
+			continue
+		}
+		c.check(t, action)
+	}
+}
+
+func TestTarpitHonorsSubSecondDuration(t *testing.T) {
+	w := httptest.NewRecorder()
+
+	start := time.Now()
+	tarpit(w, 200*time.Millisecond)
+	elapsed := time.Since(start)
+
+	if elapsed > 600*time.Millisecond {
+		t.Fatalf("tarpit(200ms) took %s, want it to honor the configured duration instead of rounding up to whole seconds", elapsed)
+	}
+	if w.Body.Len() == 0 {
+		t.Fatalf("tarpit wrote no bytes")
+	}
+}
@@ -0,0 +1,62 @@
+package scanblock
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// CacheEntry tracks what scanblock knows about a single IP. TotalRequests
+// and ScanRequests are sliding-window counters rather than monotonic totals,
+// so MinScanPercent reflects recent behavior rather than a client's entire
+// history.
+type CacheEntry struct {
+	FirstSeen atomic.Int64
+	LastSeen  atomic.Int64
+	Blocking  atomic.Bool
+
+	TotalRequests *SlidingCounter
+	ScanRequests  *SlidingCounter
+}
+
+// Cache holds a CacheEntry per IP seen by the plugin.
+type Cache struct {
+	mu            sync.RWMutex
+	entries       map[string]*CacheEntry
+	windowSeconds int
+}
+
+// NewCache returns an empty Cache whose entries use the default sliding
+// window.
+func NewCache() *Cache {
+	return NewCacheWithWindow(DefaultWindowSeconds)
+}
+
+// NewCacheWithWindow returns an empty Cache whose entries' counters cover
+// windowSeconds.
+func NewCacheWithWindow(windowSeconds int) *Cache {
+	return &Cache{
+		entries:       map[string]*CacheEntry{},
+		windowSeconds: windowSeconds,
+	}
+}
+
+// GetEntry returns the entry for ip, or nil if it isn't known yet.
+func (c *Cache) GetEntry(ip string) *CacheEntry {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.entries[ip]
+}
+
+// CreateEntry creates and stores a fresh entry for ip.
+func (c *Cache) CreateEntry(ip string) *CacheEntry {
+	entry := &CacheEntry{
+		TotalRequests: NewSlidingCounter(c.windowSeconds),
+		ScanRequests:  NewSlidingCounter(c.windowSeconds),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[ip] = entry
+
+	return entry
+}
@@ -0,0 +1,206 @@
+package scanblock
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Checker holds the parsed allow- and deny-lists used to short-circuit scan
+// detection for known-good or known-bad IPs/CIDRs. Exact IPs are looked up in
+// a map, CIDRs are matched against a slice of *net.IPNet. All fields are
+// guarded by mu so Reload can swap them in while requests are being served.
+type Checker struct {
+	mu sync.RWMutex
+
+	allowIPs  map[string]bool
+	allowNets []*net.IPNet
+
+	denyIPs  map[string]bool
+	denyNets []*net.IPNet
+}
+
+// NewChecker builds a Checker from the given allow and deny entries. Entries
+// may be single IPs or CIDRs.
+func NewChecker(allow, deny []string) (*Checker, error) {
+	checker := &Checker{}
+	if err := checker.Reload(allow, deny); err != nil {
+		return nil, err
+	}
+	return checker, nil
+}
+
+// Reload replaces the allow and deny lists with freshly parsed entries. It is
+// safe to call concurrently with ContainsIP/ContainsDenyIP.
+func (c *Checker) Reload(allow, deny []string) error {
+	allowIPs, allowNets, err := parseIPList(allow)
+	if err != nil {
+		return fmt.Errorf("scanblock: invalid allow list: %w", err)
+	}
+
+	denyIPs, denyNets, err := parseIPList(deny)
+	if err != nil {
+		return fmt.Errorf("scanblock: invalid deny list: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.allowIPs = allowIPs
+	c.allowNets = allowNets
+	c.denyIPs = denyIPs
+	c.denyNets = denyNets
+
+	return nil
+}
+
+// ContainsIP reports whether addr matches the allow-list.
+func (c *Checker) ContainsIP(addr net.IP) bool {
+	return c.contains(addr, false)
+}
+
+// ContainsDenyIP reports whether addr matches the deny-list.
+func (c *Checker) ContainsDenyIP(addr net.IP) bool {
+	return c.contains(addr, true)
+}
+
+func (c *Checker) contains(addr net.IP, deny bool) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	ips, nets := c.allowIPs, c.allowNets
+	if deny {
+		ips, nets = c.denyIPs, c.denyNets
+	}
+
+	if ips[addr.String()] {
+		return true
+	}
+
+	for _, ipNet := range nets {
+		if ipNet.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseIPList splits entries into exact IPs and CIDR networks.
+func parseIPList(entries []string) (map[string]bool, []*net.IPNet, error) {
+	ips := make(map[string]bool, len(entries))
+	var nets []*net.IPNet
+
+	for _, entry := range entries {
+		if ipAddr := net.ParseIP(entry); ipAddr != nil {
+			ips[ipAddr.String()] = true
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse IP or CIDR %q: %w", entry, err)
+		}
+		nets = append(nets, ipNet)
+	}
+
+	return ips, nets, nil
+}
+
+// startListWatcher polls the allow/deny list files referenced by config for
+// changes and reloads checker when either one's modification time advances.
+// It exits when ctx is done. A no-op if neither file is configured.
+func startListWatcher(ctx context.Context, checker *Checker, config *Config, interval time.Duration) {
+	if config.IPAllowListFile == "" && config.IPDenyListFile == "" {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		var lastAllowMod, lastDenyMod time.Time
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				allowMod, allowChanged := fileChanged(config.IPAllowListFile, lastAllowMod)
+				denyMod, denyChanged := fileChanged(config.IPDenyListFile, lastDenyMod)
+				if !allowChanged && !denyChanged {
+					continue
+				}
+				lastAllowMod, lastDenyMod = allowMod, denyMod
+
+				allow, err := mergeIPList(config.IPAllowList, config.IPAllowListFile)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "scanblock plugin failed to reload IP allow list: %v\n", err)
+					continue
+				}
+				deny, err := mergeIPList(config.IPDenyList, config.IPDenyListFile)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "scanblock plugin failed to reload IP deny list: %v\n", err)
+					continue
+				}
+
+				if err := checker.Reload(allow, deny); err != nil {
+					fmt.Fprintf(os.Stderr, "scanblock plugin failed to reload IP lists: %v\n", err)
+				}
+			}
+		}
+	}()
+}
+
+// fileChanged reports the file's current modification time and whether it is
+// newer than last. Missing or unconfigured files never report a change.
+func fileChanged(path string, last time.Time) (time.Time, bool) {
+	if path == "" {
+		return last, false
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return last, false
+	}
+
+	return info.ModTime(), info.ModTime().After(last)
+}
+
+// mergeIPList combines the statically configured entries with the contents of
+// file, if any. It's a no-op passthrough when file is empty.
+func mergeIPList(static []string, file string) ([]string, error) {
+	if file == "" {
+		return static, nil
+	}
+
+	fileEntries, err := readIPListFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(append([]string{}, static...), fileEntries...), nil
+}
+
+// readIPListFile reads one IP/CIDR per line from path, skipping blank lines
+// and lines starting with "#".
+func readIPListFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, line)
+	}
+
+	return entries, nil
+}
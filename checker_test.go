@@ -0,0 +1,82 @@
+package scanblock
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckerContainsIPExactAndCIDR(t *testing.T) {
+	checker, err := NewChecker([]string{"203.0.113.5", "198.51.100.0/24"}, []string{"192.0.2.9"})
+	if err != nil {
+		t.Fatalf("NewChecker: %v", err)
+	}
+
+	cases := []struct {
+		ip        string
+		wantAllow bool
+		wantDeny  bool
+	}{
+		{"203.0.113.5", true, false},
+		{"198.51.100.42", true, false},
+		{"198.51.101.1", false, false},
+		{"192.0.2.9", false, true},
+	}
+
+	for _, c := range cases {
+		ip := net.ParseIP(c.ip)
+		if got := checker.ContainsIP(ip); got != c.wantAllow {
+			t.Errorf("ContainsIP(%s) = %v, want %v", c.ip, got, c.wantAllow)
+		}
+		if got := checker.ContainsDenyIP(ip); got != c.wantDeny {
+			t.Errorf("ContainsDenyIP(%s) = %v, want %v", c.ip, got, c.wantDeny)
+		}
+	}
+}
+
+func TestCheckerReload(t *testing.T) {
+	checker, err := NewChecker([]string{"203.0.113.5"}, nil)
+	if err != nil {
+		t.Fatalf("NewChecker: %v", err)
+	}
+
+	ip := net.ParseIP("203.0.113.5")
+	if !checker.ContainsIP(ip) {
+		t.Fatalf("ContainsIP(%s) = false before reload, want true", ip)
+	}
+
+	if err := checker.Reload(nil, []string{"203.0.113.5"}); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+
+	if checker.ContainsIP(ip) {
+		t.Fatalf("ContainsIP(%s) = true after reload dropped it from the allow-list", ip)
+	}
+	if !checker.ContainsDenyIP(ip) {
+		t.Fatalf("ContainsDenyIP(%s) = false after reload added it to the deny-list", ip)
+	}
+}
+
+func TestMergeIPListReadsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "denylist.txt")
+	contents := "# comment\n192.0.2.1\n\n198.51.100.0/24\n"
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	merged, err := mergeIPList([]string{"203.0.113.5"}, path)
+	if err != nil {
+		t.Fatalf("mergeIPList: %v", err)
+	}
+
+	want := []string{"203.0.113.5", "192.0.2.1", "198.51.100.0/24"}
+	if len(merged) != len(want) {
+		t.Fatalf("mergeIPList = %v, want %v", merged, want)
+	}
+	for i := range want {
+		if merged[i] != want[i] {
+			t.Fatalf("mergeIPList = %v, want %v", merged, want)
+		}
+	}
+}
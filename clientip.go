@@ -0,0 +1,118 @@
+package scanblock
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Client IP resolution strategies for Config.ClientIPStrategy.
+const (
+	ClientIPStrategyRealIP         = "real-ip"
+	ClientIPStrategyXForwardedFor  = "x-forwarded-for"
+	ClientIPStrategyRemoteAddr     = "remote-addr"
+	ClientIPStrategyCFConnectingIP = "cf-connecting-ip"
+)
+
+// CFConnectingIP is the header Cloudflare sets to the original client IP.
+const CFConnectingIP = "Cf-Connecting-Ip"
+
+// clientIP resolves the IP scanblock should evaluate for r, according to
+// Config.ClientIPStrategy. Strategies that trust a header only honor it when
+// the immediate peer is in Config.TrustedProxies; otherwise the header is
+// ignored and the immediate peer's address is used instead, so an untrusted
+// or unparseable header still gets tracked (and potentially blocked) under
+// its real address rather than bypassing the plugin entirely.
+func (sb *ScanBlock) clientIP(r *http.Request) (net.IP, error) {
+	peerIP, err := remoteAddrIP(r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch sb.config.ClientIPStrategy {
+	case ClientIPStrategyRemoteAddr:
+		return peerIP, nil
+	case ClientIPStrategyCFConnectingIP:
+		return sb.trustedHeaderIP(r, peerIP, CFConnectingIP)
+	case ClientIPStrategyXForwardedFor:
+		return sb.forwardedForIP(r, peerIP)
+	default:
+		return sb.trustedHeaderIP(r, peerIP, XRealIp)
+	}
+}
+
+// trustedHeaderIP returns the IP in header, but only if peerIP is a trusted
+// proxy. If header isn't set, or peerIP isn't trusted, or the value can't be
+// parsed, peerIP is used as-is so the request is still tracked rather than
+// bypassed.
+func (sb *ScanBlock) trustedHeaderIP(r *http.Request, peerIP net.IP, header string) (net.IP, error) {
+	value := r.Header.Get(header)
+	if value == "" {
+		return peerIP, nil
+	}
+
+	if !sb.trustedProxies.ContainsIP(peerIP) {
+		fmt.Fprintf(os.Stderr, "scanblock plugin received %s from untrusted peer %s, ignoring header\n", header, peerIP)
+		return peerIP, nil
+	}
+
+	ip := net.ParseIP(strings.TrimSpace(value))
+	if ip == nil {
+		fmt.Fprintf(os.Stderr, "scanblock plugin failed to parse %s value %q, ignoring header\n", header, value)
+		return peerIP, nil
+	}
+
+	return ip, nil
+}
+
+// forwardedForIP walks X-Forwarded-For right-to-left, skipping trusted
+// proxies, and returns the first untrusted hop, which is the actual client.
+// If peerIP isn't trusted or the header can't be parsed, peerIP is used as-is
+// so the request is still tracked rather than bypassed.
+func (sb *ScanBlock) forwardedForIP(r *http.Request, peerIP net.IP) (net.IP, error) {
+	value := r.Header.Get("X-Forwarded-For")
+	if value == "" {
+		return peerIP, nil
+	}
+
+	if !sb.trustedProxies.ContainsIP(peerIP) {
+		fmt.Fprintf(os.Stderr, "scanblock plugin received X-Forwarded-For from untrusted peer %s, ignoring header\n", peerIP)
+		return peerIP, nil
+	}
+
+	hops := strings.Split(value, ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			continue
+		}
+		if !sb.trustedProxies.ContainsIP(hop) {
+			return hop, nil
+		}
+	}
+
+	// Every hop was itself a trusted proxy; fall back to the left-most entry.
+	leftMost := net.ParseIP(strings.TrimSpace(hops[0]))
+	if leftMost == nil {
+		fmt.Fprintf(os.Stderr, "scanblock plugin failed to parse X-Forwarded-For value %q, ignoring header\n", value)
+		return peerIP, nil
+	}
+	return leftMost, nil
+}
+
+// remoteAddrIP returns the IP of the immediate peer, stripped of its port.
+func remoteAddrIP(r *http.Request) (net.IP, error) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, fmt.Errorf("scanblock: failed to parse remote address %q", r.RemoteAddr)
+	}
+
+	return ip, nil
+}
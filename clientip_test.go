@@ -0,0 +1,185 @@
+package scanblock
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestScanBlock(t *testing.T, trustedProxies []string) *ScanBlock {
+	t.Helper()
+
+	trusted, err := NewChecker(trustedProxies, nil)
+	if err != nil {
+		t.Fatalf("NewChecker: %v", err)
+	}
+
+	return &ScanBlock{
+		config:         &Config{},
+		trustedProxies: trusted,
+	}
+}
+
+func TestClientIPUntrustedPeerFallsBackToPeer(t *testing.T) {
+	sb := newTestScanBlock(t, nil)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "203.0.113.5:1234"
+	r.Header.Set(XRealIp, "198.51.100.9")
+
+	ip, err := sb.clientIP(r)
+	if err != nil {
+		t.Fatalf("clientIP returned error %v, want fail-closed fallback", err)
+	}
+	if ip.String() != "203.0.113.5" {
+		t.Fatalf("clientIP = %s, want the untrusted peer's address 203.0.113.5", ip)
+	}
+}
+
+func TestClientIPTrustedPeerUsesHeader(t *testing.T) {
+	sb := newTestScanBlock(t, []string{"203.0.113.5"})
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "203.0.113.5:1234"
+	r.Header.Set(XRealIp, "198.51.100.9")
+
+	ip, err := sb.clientIP(r)
+	if err != nil {
+		t.Fatalf("clientIP: %v", err)
+	}
+	if ip.String() != "198.51.100.9" {
+		t.Fatalf("clientIP = %s, want header value 198.51.100.9", ip)
+	}
+}
+
+func TestClientIPUnparseableHeaderFallsBackToPeer(t *testing.T) {
+	sb := newTestScanBlock(t, []string{"203.0.113.5"})
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "203.0.113.5:1234"
+	r.Header.Set(XRealIp, "not-an-ip")
+
+	ip, err := sb.clientIP(r)
+	if err != nil {
+		t.Fatalf("clientIP returned error %v, want fail-closed fallback", err)
+	}
+	if ip.String() != "203.0.113.5" {
+		t.Fatalf("clientIP = %s, want the peer's address 203.0.113.5", ip)
+	}
+}
+
+func TestClientIPCFConnectingIPStrategy(t *testing.T) {
+	sb := newTestScanBlock(t, []string{"203.0.113.5"})
+	sb.config.ClientIPStrategy = ClientIPStrategyCFConnectingIP
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "203.0.113.5:1234"
+	r.Header.Set(CFConnectingIP, "198.51.100.9")
+
+	ip, err := sb.clientIP(r)
+	if err != nil {
+		t.Fatalf("clientIP: %v", err)
+	}
+	if ip.String() != "198.51.100.9" {
+		t.Fatalf("clientIP = %s, want header value 198.51.100.9", ip)
+	}
+}
+
+func TestClientIPRemoteAddrStrategyIgnoresHeaders(t *testing.T) {
+	sb := newTestScanBlock(t, []string{"203.0.113.5"})
+	sb.config.ClientIPStrategy = ClientIPStrategyRemoteAddr
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "203.0.113.5:1234"
+	r.Header.Set(XRealIp, "198.51.100.9")
+
+	ip, err := sb.clientIP(r)
+	if err != nil {
+		t.Fatalf("clientIP: %v", err)
+	}
+	if ip.String() != "203.0.113.5" {
+		t.Fatalf("clientIP = %s, want the peer address regardless of headers", ip)
+	}
+}
+
+func TestClientIPXForwardedFor(t *testing.T) {
+	cases := []struct {
+		name           string
+		trustedProxies []string
+		peer           string
+		xff            string
+		want           string
+	}{
+		{
+			name:           "single trusted hop and real client",
+			trustedProxies: []string{"203.0.113.5"},
+			peer:           "203.0.113.5",
+			xff:            "198.51.100.9",
+			want:           "198.51.100.9",
+		},
+		{
+			name:           "multiple trusted hops",
+			trustedProxies: []string{"203.0.113.5", "203.0.113.6"},
+			peer:           "203.0.113.5",
+			xff:            "198.51.100.9, 203.0.113.6",
+			want:           "198.51.100.9",
+		},
+		{
+			name:           "untrusted hop in the middle stops the walk",
+			trustedProxies: []string{"203.0.113.5", "203.0.113.6"},
+			peer:           "203.0.113.5",
+			xff:            "198.51.100.9, 198.51.100.10, 203.0.113.6",
+			want:           "198.51.100.10",
+		},
+		{
+			name:           "all hops trusted falls back to left-most",
+			trustedProxies: []string{"203.0.113.5", "203.0.113.6"},
+			peer:           "203.0.113.5",
+			xff:            "203.0.113.6, 203.0.113.6",
+			want:           "203.0.113.6",
+		},
+		{
+			name:           "unparseable left-most hop falls back to peer",
+			trustedProxies: []string{"203.0.113.5", "203.0.113.6"},
+			peer:           "203.0.113.5",
+			xff:            "not-an-ip, 203.0.113.6",
+			want:           "203.0.113.5",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			sb := newTestScanBlock(t, c.trustedProxies)
+			sb.config.ClientIPStrategy = ClientIPStrategyXForwardedFor
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = c.peer + ":1234"
+			r.Header.Set("X-Forwarded-For", c.xff)
+
+			ip, err := sb.clientIP(r)
+			if err != nil {
+				t.Fatalf("clientIP: %v", err)
+			}
+			if ip.String() != c.want {
+				t.Fatalf("clientIP = %s, want %s", ip, c.want)
+			}
+		})
+	}
+}
+
+func TestClientIPXForwardedForUntrustedPeerFallsBackToPeer(t *testing.T) {
+	sb := newTestScanBlock(t, nil)
+	sb.config.ClientIPStrategy = ClientIPStrategyXForwardedFor
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "203.0.113.5:1234"
+	r.Header.Set("X-Forwarded-For", "198.51.100.9")
+
+	ip, err := sb.clientIP(r)
+	if err != nil {
+		t.Fatalf("clientIP returned error %v, want fail-closed fallback", err)
+	}
+	if ip.String() != "203.0.113.5" {
+		t.Fatalf("clientIP = %s, want the untrusted peer's address 203.0.113.5", ip)
+	}
+}
@@ -0,0 +1,111 @@
+package scanblock
+
+import (
+	"sync"
+	"time"
+)
+
+// SlidingCounter is a decaying request counter backed by a ring of
+// one-second buckets covering a configurable window. Unlike a monotonic
+// counter, Sum() only reflects activity from the last windowSeconds, so a
+// long-lived legitimate client isn't permanently tainted by a brief burst of
+// scanning from another client sharing its NAT. Rotating stale buckets out
+// and adding to the current one must happen as one atomic step (otherwise a
+// write can land in a bucket a concurrent rotation is mid-way through
+// clearing and get silently wiped), so every access is guarded by mu rather
+// than relying on the buckets' own atomicity.
+//
+// This deliberately deviates from a lock-free, per-bucket atomic.Uint64
+// design: that version published a new "last rotation" timestamp via
+// CompareAndSwap before the zeroing loop that rotation implied had actually
+// run, letting a concurrent Add/Sum land a write in a bucket the CAS winner
+// was still mid-way through clearing. There's no lock-free way to make
+// "check the rotation boundary" and "zero the passed-over buckets" atomic
+// without either a mutex or a much heavier per-bucket scheme, so the mutex
+// stays on the request path.
+type SlidingCounter struct {
+	mu            sync.Mutex
+	windowSeconds int64
+	buckets       []uint64
+	lastRotation  int64
+}
+
+// NewSlidingCounter returns a SlidingCounter covering windowSeconds,
+// defaulting to DefaultWindowSeconds if windowSeconds <= 0.
+func NewSlidingCounter(windowSeconds int) *SlidingCounter {
+	if windowSeconds <= 0 {
+		windowSeconds = DefaultWindowSeconds
+	}
+
+	return &SlidingCounter{
+		windowSeconds: int64(windowSeconds),
+		buckets:       make([]uint64, windowSeconds),
+	}
+}
+
+// Add increments the bucket for the current second by n, first rotating out
+// any buckets the window has passed over since the last write.
+func (c *SlidingCounter) Add(n uint64) {
+	now := time.Now().Unix()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rotate(now)
+	c.buckets[c.bucketIndex(now)] += n
+}
+
+// Sum totals the buckets still inside the window, rotating out any that
+// have gone stale since the last write.
+func (c *SlidingCounter) Sum() uint64 {
+	now := time.Now().Unix()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rotate(now)
+
+	var total uint64
+	for _, bucket := range c.buckets {
+		total += bucket
+	}
+	return total
+}
+
+// Store resets the counter and seeds it with n, as if it had just been
+// observed. Used to warm an entry from a persisted StoreRecord, not on the
+// request hot path.
+func (c *SlidingCounter) Store(n uint64) {
+	now := time.Now().Unix()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range c.buckets {
+		c.buckets[i] = 0
+	}
+	c.lastRotation = now
+	c.buckets[c.bucketIndex(now)] = n
+}
+
+func (c *SlidingCounter) bucketIndex(now int64) int64 {
+	return ((now % c.windowSeconds) + c.windowSeconds) % c.windowSeconds
+}
+
+// rotate zeroes every bucket for the seconds between the last observed
+// rotation and now, so stale counts from outside the window don't linger.
+// Callers must hold mu.
+func (c *SlidingCounter) rotate(now int64) {
+	if now <= c.lastRotation {
+		return
+	}
+
+	start := c.lastRotation + 1
+	if now-start >= c.windowSeconds {
+		// More than a full window has elapsed; no point zeroing buckets
+		// more than once.
+		start = now - c.windowSeconds + 1
+	}
+
+	for ts := start; ts <= now; ts++ {
+		c.buckets[c.bucketIndex(ts)] = 0
+	}
+	c.lastRotation = now
+}
@@ -0,0 +1,40 @@
+package scanblock
+
+import (
+	"log/slog"
+	"os"
+)
+
+// Logger receives block events recorded by the plugin. It replaces the
+// original ad-hoc fmt.Fprint(os.Stdout, ...) emission so operators can plug
+// in their own structured logging.
+type Logger interface {
+	LogBlock(entry BlockLog)
+}
+
+// slogLogger adapts *slog.Logger to Logger.
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+// NewSlogLogger returns the default Logger, which emits BlockLog entries as
+// structured records via slog. A nil logger falls back to slog's default
+// JSON handler on stdout.
+func NewSlogLogger(logger *slog.Logger) Logger {
+	if logger == nil {
+		logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	}
+	return &slogLogger{logger: logger}
+}
+
+func (l *slogLogger) LogBlock(entry BlockLog) {
+	l.logger.Info("scanblock blocked IP",
+		slog.String("type", entry.Type),
+		slog.String("name", entry.Name),
+		slog.String("ip", entry.Ip),
+		slog.String("findTime", entry.FindTime),
+		slog.String("blockTime", entry.BlockTime),
+		slog.Uint64("total", entry.Total),
+		slog.Uint64("err", entry.Err),
+	)
+}
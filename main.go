@@ -2,9 +2,7 @@ package scanblock
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"net"
 	"net/http"
 	"os"
 	"time"
@@ -12,18 +10,16 @@ import (
 
 // Default config values.
 const (
-	DefaultMinScanRequests = 10
-	DefaultMinScanPercent  = 25       // %
-	DefaultBlockSeconds    = 600      // 10m
-	DefaultRememberSeconds = 6 * 3600 // 6h
-	XRealIp                = "X-Real-Ip"
+	DefaultMinScanRequests       = 10
+	DefaultMinScanPercent        = 25       // %
+	DefaultBlockSeconds          = 600      // 10m
+	DefaultRememberSeconds       = 6 * 3600 // 6h
+	DefaultListReloadSeconds     = 30       // 30s
+	DefaultWindowSeconds         = 60       // 60s
+	DefaultStoreFileFlushSeconds = 10       // 10s
+	XRealIp                      = "X-Real-Ip"
 )
 
-type Checker struct {
-	allowIPs    []*net.IP
-	allowIPsNet []*net.IPNet
-}
-
 // Config is the plugin configuration.
 type Config struct {
 	// MinScanRequests defines the minimum 4xx responses to observe before
@@ -43,9 +39,16 @@ type Config struct {
 	BlockPrivate bool
 
 	// PlayGames defines if the the plugin should respond with random 4xx status
-	// codes or even kill the connection sometimes.
+	// codes or even kill the connection sometimes. It's a shortcut for a
+	// randomized BlockAction.
 	PlayGames bool
 
+	// BlockAction defines how a blocked request is answered: "drop" (hijack
+	// and close the connection, the default), "status:<code>" (return a
+	// fixed status code), "tarpit:<duration>" (slow-drip bytes before
+	// closing), or "redirect:<url>".
+	BlockAction string `json:"blockAction,omitempty"`
+
 	// BlockSeconds defines for how many seconds an IP should be blocked.
 	BlockSeconds int
 
@@ -53,7 +56,77 @@ type Config struct {
 	// should be cached after it was last seen.
 	RememberSeconds int
 
+	// WindowSeconds defines the size of the sliding window TotalRequests and
+	// ScanRequests are summed over, so old activity decays out instead of
+	// accumulating forever.
+	WindowSeconds int `json:"windowSeconds,omitempty"`
+
 	IPAllowList []string `json:"ipAllowList,omitempty"`
+
+	// IPDenyList defines IPs/CIDRs that are permanently blocked, regardless of
+	// scan thresholds.
+	IPDenyList []string `json:"ipDenyList,omitempty"`
+
+	// IPAllowListFile, if set, points to a file containing one IP/CIDR per
+	// line that is merged with IPAllowList and watched for changes.
+	IPAllowListFile string `json:"ipAllowListFile,omitempty"`
+
+	// IPDenyListFile, if set, points to a file containing one IP/CIDR per
+	// line that is merged with IPDenyList and watched for changes.
+	IPDenyListFile string `json:"ipDenyListFile,omitempty"`
+
+	// ListReloadSeconds defines how often the allow/deny list files are
+	// checked for changes.
+	ListReloadSeconds int `json:"listReloadSeconds,omitempty"`
+
+	// StoreType selects the persistence backend for block state: "" (memory
+	// only), "file", or "redis".
+	StoreType string `json:"storeType,omitempty"`
+
+	// StoreFile is the gzipped JSON snapshot path used by the "file" store.
+	StoreFile string `json:"storeFile,omitempty"`
+
+	// StoreFileRotateCount defines how many rotated snapshots the "file"
+	// store keeps around.
+	StoreFileRotateCount int `json:"storeFileRotateCount,omitempty"`
+
+	// StoreFileFlushSeconds defines how often the "file" store's in-memory
+	// state is batched to disk, instead of rewriting the snapshot on every
+	// single tracked-IP state change.
+	StoreFileFlushSeconds int `json:"storeFileFlushSeconds,omitempty"`
+
+	// RedisAddr is the "host:port" of the Redis instance used by the "redis"
+	// store.
+	RedisAddr string `json:"redisAddr,omitempty"`
+
+	// RedisPassword authenticates against Redis, if set.
+	RedisPassword string `json:"redisPassword,omitempty"`
+
+	// RedisDB selects the Redis logical database.
+	RedisDB int `json:"redisDb,omitempty"`
+
+	// RedisKeyPrefix namespaces the keys written by the "redis" store.
+	RedisKeyPrefix string `json:"redisKeyPrefix,omitempty"`
+
+	// MetricsAddr, if set, is the "host:port" the Prometheus-compatible
+	// /metrics endpoint is served on.
+	MetricsAddr string `json:"metricsAddr,omitempty"`
+
+	// TrustedProxies lists the IPs/CIDRs allowed to set client-IP headers
+	// such as X-Real-Ip or X-Forwarded-For.
+	TrustedProxies []string `json:"trustedProxies,omitempty"`
+
+	// ClientIPStrategy selects how the client IP is resolved: "real-ip"
+	// (the default, reads X-Real-Ip), "x-forwarded-for" (walks the chain
+	// right-to-left skipping trusted proxies), "remote-addr" (ignores
+	// headers entirely), or "cf-connecting-ip".
+	ClientIPStrategy string `json:"clientIpStrategy,omitempty"`
+
+	// Logger, if set, receives block events instead of the default
+	// slog-based Logger. Not part of the declarative plugin config since a
+	// Logger can't be expressed in YAML/JSON; set it on the *Config passed
+	// to New when constructing the plugin directly from Go.
+	Logger Logger `json:"-"`
 }
 
 // CreateConfig creates the default plugin configuration.
@@ -63,11 +136,17 @@ func CreateConfig() *Config {
 
 // ScanBlock is a scan blocking plugin.
 type ScanBlock struct {
-	next    http.Handler
-	name    string
-	config  *Config
-	checker *Checker
-	cache   *Cache
+	next           http.Handler
+	name           string
+	config         *Config
+	checker        *Checker
+	trustedProxies *Checker
+	cache          *Cache
+	store          Store
+	logger         Logger
+	metrics        *Metrics
+
+	blockAction blockAction
 }
 
 type BlockLog struct {
@@ -95,24 +174,130 @@ func New(ctx context.Context, next http.Handler, config *Config, name string) (h
 	if config.RememberSeconds == 0 {
 		config.RememberSeconds = DefaultRememberSeconds
 	}
+	if config.ListReloadSeconds == 0 {
+		config.ListReloadSeconds = DefaultListReloadSeconds
+	}
+	if config.WindowSeconds == 0 {
+		config.WindowSeconds = DefaultWindowSeconds
+	}
+	if config.StoreFileFlushSeconds == 0 {
+		config.StoreFileFlushSeconds = DefaultStoreFileFlushSeconds
+	}
+
+	allow, err := mergeIPList(config.IPAllowList, config.IPAllowListFile)
+	if err != nil {
+		return nil, fmt.Errorf("scanblock: failed to load IP allow list: %w", err)
+	}
+	deny, err := mergeIPList(config.IPDenyList, config.IPDenyListFile)
+	if err != nil {
+		return nil, fmt.Errorf("scanblock: failed to load IP deny list: %w", err)
+	}
+
+	checker, err := NewChecker(allow, deny)
+	if err != nil {
+		return nil, fmt.Errorf("scanblock: failed to build IP checker: %w", err)
+	}
+
+	startListWatcher(ctx, checker, config, time.Duration(config.ListReloadSeconds)*time.Second)
+
+	trustedProxies, err := NewChecker(config.TrustedProxies, nil)
+	if err != nil {
+		return nil, fmt.Errorf("scanblock: failed to build trusted proxy checker: %w", err)
+	}
+
+	blockAction, err := parseBlockAction(config.BlockAction)
+	if err != nil {
+		return nil, fmt.Errorf("scanblock: invalid block action: %w", err)
+	}
+
+	store, err := newStore(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("scanblock: failed to build store: %w", err)
+	}
 
-	checker := NewChecker(config.IPAllowList)
+	cache := NewCacheWithWindow(config.WindowSeconds)
+	if store != nil {
+		if err := warmCache(cache, store); err != nil {
+			fmt.Fprintf(os.Stderr, "scanblock plugin failed to warm cache from store: %v\n", err)
+		}
+	}
+
+	metrics := newMetrics()
+	startMetricsServer(ctx, config.MetricsAddr, metrics)
+
+	logger := config.Logger
+	if logger == nil {
+		logger = NewSlogLogger(nil)
+	}
 
 	// Log the instantiation of the plugin, including configuration.
 	fmt.Fprintf(os.Stdout, "creating scanblock plugin %q with config: %+v\n", name, config)
 
 	// Return new plugin instance.
 	return &ScanBlock{
-		next:    next,
-		name:    name,
-		config:  config,
-		checker: checker,
-		cache:   NewCache(),
+		next:           next,
+		name:           name,
+		config:         config,
+		checker:        checker,
+		trustedProxies: trustedProxies,
+		cache:          cache,
+		store:          store,
+		logger:         logger,
+		metrics:        metrics,
+		blockAction:    blockAction,
 	}, nil
 }
 
+// warmCache restores every record in store into cache, so a restarted or
+// freshly scheduled instance doesn't forget offenders other instances
+// already learned about.
+func warmCache(cache *Cache, store Store) error {
+	records, err := store.List()
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		entry := cache.CreateEntry(record.IP)
+		entry.FirstSeen.Store(record.FirstSeen)
+		entry.LastSeen.Store(record.LastSeen)
+		entry.TotalRequests.Store(record.TotalRequests)
+		entry.ScanRequests.Store(record.ScanRequests)
+		entry.Blocking.Store(record.Blocking)
+	}
+
+	return nil
+}
+
+// persist asynchronously writes entry's current state for ipString to the
+// configured store, if any. Called on every state transition: first-seen,
+// block, and unblock.
+func (sb *ScanBlock) persist(ipString string, entry *CacheEntry) {
+	if sb.store == nil {
+		return
+	}
+
+	record := &StoreRecord{
+		IP:            ipString,
+		FirstSeen:     entry.FirstSeen.Load(),
+		LastSeen:      entry.LastSeen.Load(),
+		TotalRequests: entry.TotalRequests.Sum(),
+		ScanRequests:  entry.ScanRequests.Sum(),
+		Blocking:      entry.Blocking.Load(),
+	}
+	ttl := time.Duration(sb.config.RememberSeconds) * time.Second
+
+	go func() {
+		if err := sb.store.Put(ipString, record, ttl); err != nil {
+			fmt.Fprintf(os.Stderr, "scanblock plugin failed to persist entry for %s: %v\n", ipString, err)
+		}
+	}()
+}
+
 // ServeHTTP handles a http request.
 func (sb *ScanBlock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	sb.metrics.RecordRequest()
+
 	// Check if request should be blocked and block it.
 	entry, ok := sb.check(r)
 
@@ -137,6 +322,7 @@ func (sb *ScanBlock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	wrappedResponseWriter := &ResponseWriter{
 		ResponseWriter: w,
 		cacheEntry:     entry,
+		metrics:        sb.metrics,
 	}
 
 	// Continue with next handler.
@@ -144,13 +330,10 @@ func (sb *ScanBlock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 func (sb *ScanBlock) check(r *http.Request) (entry *CacheEntry, ok bool) {
-	// Parse remote address.
-	host := r.Header.Get(XRealIp)
-
-	// Parse remote IP address.
-	remoteIP := net.ParseIP(host)
-	if remoteIP == nil {
-		fmt.Fprintf(os.Stderr, "scanblock plugin failed to parse remote IP %q", host)
+	// Resolve the client IP per the configured strategy.
+	remoteIP, err := sb.clientIP(r)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "scanblock plugin failed to resolve client IP: %v\n", err)
 		return nil, false
 	}
 
@@ -164,7 +347,29 @@ func (sb *ScanBlock) check(r *http.Request) (entry *CacheEntry, ok bool) {
 		return nil, false
 	}
 
-	if sb.checker != nil && sb.checker.ContainsIP(remoteIP) {
+	// Deny-listed IPs are blocked outright, without waiting for scan
+	// thresholds. They still go through the cache so repeat requests from
+	// the same IP only count one block start, matching how scan-triggered
+	// blocks are recorded below.
+	if sb.checker.ContainsDenyIP(remoteIP) {
+		ipString := remoteIP.String()
+		entry := sb.cache.GetEntry(ipString)
+		if entry == nil {
+			entry = sb.cache.CreateEntry(ipString)
+			entry.FirstSeen.Store(time.Now().Unix())
+			sb.persist(ipString, entry)
+		}
+		entry.LastSeen.Store(time.Now().Unix())
+
+		if !entry.Blocking.Swap(true) {
+			sb.persist(ipString, entry)
+			sb.metrics.RecordBlockStart()
+		}
+		return nil, true
+	}
+
+	if sb.checker.ContainsIP(remoteIP) {
+		sb.metrics.RecordAllowListHit()
 		return nil, false
 	}
 
@@ -175,6 +380,7 @@ func (sb *ScanBlock) check(r *http.Request) (entry *CacheEntry, ok bool) {
 		// If not yet in cache, create an entry.
 		entry = sb.cache.CreateEntry(ipString)
 		entry.FirstSeen.Store(time.Now().Unix())
+		sb.persist(ipString, entry)
 	}
 
 	// Update last seen when we're done.
@@ -188,81 +394,40 @@ func (sb *ScanBlock) check(r *http.Request) (entry *CacheEntry, ok bool) {
 		// Unblock if time since last seen is greater than block duration.
 		if entry.LastSeen.Load() < time.Now().Add(-time.Duration(sb.config.BlockSeconds)*time.Second).Unix() {
 			entry.Blocking.Store(false)
+			sb.persist(ipString, entry)
+			sb.metrics.RecordBlockEnd()
 			return entry, true
 		}
 
 		// Otherwise, continue to block.
 		return nil, true
-	case entry.ScanRequests.Load() < sb.config.MinScanRequests:
+	case entry.ScanRequests.Sum() < sb.config.MinScanRequests:
 		// Not reached minimum scan requests.
 		return entry, true
-	case entry.TotalRequests.Load() < sb.config.MinTotalRequests:
+	case entry.TotalRequests.Sum() < sb.config.MinTotalRequests:
 		// Not reached minimum total requests.
 		return entry, true
-	case (float64(entry.ScanRequests.Load())/float64(entry.TotalRequests.Load()))*100 < sb.config.MinScanPercent:
+	case (float64(entry.ScanRequests.Sum())/float64(entry.TotalRequests.Sum()))*100 < sb.config.MinScanPercent:
 		// Not reached minimum scan request percentage.
 		return entry, true
 	default:
 		// All conditions for a block fulfilled, start blocking.
 
 		// Log the block.
-		b := BlockLog{
+		sb.logger.LogBlock(BlockLog{
 			Type:      "scanblock_plugin",
 			Name:      sb.name,
 			Ip:        ipString,
 			FindTime:  time.Since(time.Unix(entry.FirstSeen.Load(), 0)).Round(time.Second).String(),
 			BlockTime: (time.Duration(sb.config.BlockSeconds) * time.Second).String(),
-			Total:     entry.TotalRequests.Load(),
-			Err:       entry.ScanRequests.Load(),
-		}
-		jsonData, err := json.Marshal(b)
-		if err != nil {
-			fmt.Fprint(os.Stderr, "Error marshalling to JSON:", err)
-			return
-		}
-		fmt.Fprint(os.Stdout, string(jsonData))
+			Total:     entry.TotalRequests.Sum(),
+			Err:       entry.ScanRequests.Sum(),
+		})
 
 		// Block this IP.
 		entry.Blocking.Store(true)
+		sb.persist(ipString, entry)
+		sb.metrics.RecordBlockStart()
 		return nil, true
 	}
 }
-
-func NewChecker(allowIPs []string) *Checker {
-	if len(allowIPs) == 0 {
-		return nil
-	}
-
-	checker := &Checker{}
-
-	for _, ipMask := range allowIPs {
-		if ipAddr := net.ParseIP(ipMask); ipAddr != nil {
-			checker.allowIPs = append(checker.allowIPs, &ipAddr)
-		} else {
-			_, ipAddr, err := net.ParseCIDR(ipMask)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "scanblock plugin failed to parsing CIDR IPs %s: %w", ipAddr, err)
-				return nil
-			}
-			checker.allowIPsNet = append(checker.allowIPsNet, ipAddr)
-		}
-	}
-
-	return checker
-}
-
-func (ip *Checker) ContainsIP(addr net.IP) bool {
-	for _, allowIP := range ip.allowIPs {
-		if allowIP.Equal(addr) {
-			return true
-		}
-	}
-
-	for _, allowNet := range ip.allowIPsNet {
-		if allowNet.Contains(addr) {
-			return true
-		}
-	}
-
-	return false
-}
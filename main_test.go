@@ -0,0 +1,87 @@
+package scanblock
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCheckDenyListedIPRecordsMetricsOnce(t *testing.T) {
+	checker, err := NewChecker(nil, []string{"203.0.113.9"})
+	if err != nil {
+		t.Fatalf("NewChecker: %v", err)
+	}
+	trustedProxies, err := NewChecker(nil, nil)
+	if err != nil {
+		t.Fatalf("NewChecker: %v", err)
+	}
+
+	sb := &ScanBlock{
+		config:         &Config{BlockSeconds: 600},
+		checker:        checker,
+		trustedProxies: trustedProxies,
+		cache:          NewCache(),
+		metrics:        newMetrics(),
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "203.0.113.9:1234"
+
+	for i := 0; i < 3; i++ {
+		entry, ok := sb.check(r)
+		if entry != nil || !ok {
+			t.Fatalf("check() = (%v, %v), want (nil, true) for a deny-listed IP", entry, ok)
+		}
+	}
+
+	var buf bytes.Buffer
+	sb.metrics.WritePrometheus(&buf)
+	out := buf.String()
+
+	if !strings.Contains(out, "scanblock_blocks_issued_total 1\n") {
+		t.Fatalf("expected scanblock_blocks_issued_total to be 1 after repeated deny-list hits, got:\n%s", out)
+	}
+	if !strings.Contains(out, "scanblock_ips_blocked_current 1\n") {
+		t.Fatalf("expected scanblock_ips_blocked_current to be 1, got:\n%s", out)
+	}
+}
+
+type recordingLogger struct {
+	blocks []BlockLog
+}
+
+func (l *recordingLogger) LogBlock(entry BlockLog) {
+	l.blocks = append(l.blocks, entry)
+}
+
+func TestNewUsesInjectedLogger(t *testing.T) {
+	logger := &recordingLogger{}
+	config := &Config{
+		MinScanRequests:  1,
+		MinTotalRequests: 1,
+		MinScanPercent:   1,
+		Logger:           logger,
+	}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	handler, err := New(context.Background(), next, config, "test")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	for i := 0; i < 5; i++ {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = "198.51.100.7:1234"
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	if len(logger.blocks) == 0 {
+		t.Fatalf("expected the injected Logger to receive at least one LogBlock call")
+	}
+}
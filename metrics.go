@@ -0,0 +1,155 @@
+package scanblock
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics tracks counters and gauges for the scanblock plugin and exposes
+// them in the Prometheus text exposition format. It has no dependency on the
+// Prometheus client library, since the plugin can only rely on the standard
+// library.
+type Metrics struct {
+	requestsTotal      atomic.Uint64
+	scanRequestsTotal  atomic.Uint64
+	allowListHitsTotal atomic.Uint64
+	blocksIssuedTotal  atomic.Uint64
+	ipsBlockedCurrent  atomic.Int64
+
+	blockActionDropTotal     atomic.Uint64
+	blockActionStatusTotal   atomic.Uint64
+	blockActionTarpitTotal   atomic.Uint64
+	blockActionRedirectTotal atomic.Uint64
+
+	mu                sync.Mutex
+	statusCodesServed map[int]uint64
+}
+
+// newMetrics returns an initialized, empty Metrics.
+func newMetrics() *Metrics {
+	return &Metrics{statusCodesServed: map[int]uint64{}}
+}
+
+// RecordRequest counts every request seen by the plugin.
+func (m *Metrics) RecordRequest() {
+	m.requestsTotal.Add(1)
+}
+
+// RecordScanRequest counts a 4xx response, i.e. scan activity.
+func (m *Metrics) RecordScanRequest() {
+	m.scanRequestsTotal.Add(1)
+}
+
+// RecordAllowListHit counts a request that bypassed scan detection because
+// its IP matched the allow-list.
+func (m *Metrics) RecordAllowListHit() {
+	m.allowListHitsTotal.Add(1)
+}
+
+// RecordBlockStart counts a newly blocked IP.
+func (m *Metrics) RecordBlockStart() {
+	m.blocksIssuedTotal.Add(1)
+	m.ipsBlockedCurrent.Add(1)
+}
+
+// RecordBlockEnd counts an IP coming out of its block window.
+func (m *Metrics) RecordBlockEnd() {
+	m.ipsBlockedCurrent.Add(-1)
+}
+
+// RecordBlockAction counts which response a blocked request received.
+func (m *Metrics) RecordBlockAction(kind blockActionKind) {
+	switch kind {
+	case blockActionStatus:
+		m.blockActionStatusTotal.Add(1)
+	case blockActionTarpit:
+		m.blockActionTarpitTotal.Add(1)
+	case blockActionRedirect:
+		m.blockActionRedirectTotal.Add(1)
+	default:
+		m.blockActionDropTotal.Add(1)
+	}
+}
+
+// RecordStatusCode counts a response status code observed by ResponseWriter.
+func (m *Metrics) RecordStatusCode(code int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.statusCodesServed[code]++
+}
+
+// WritePrometheus renders all metrics in the Prometheus text exposition format.
+func (m *Metrics) WritePrometheus(w io.Writer) {
+	fmt.Fprintf(w, "# HELP scanblock_requests_total Total requests seen by the plugin.\n")
+	fmt.Fprintf(w, "# TYPE scanblock_requests_total counter\n")
+	fmt.Fprintf(w, "scanblock_requests_total %d\n", m.requestsTotal.Load())
+
+	fmt.Fprintf(w, "# HELP scanblock_scan_requests_total Total requests that looked like scanning (4xx responses).\n")
+	fmt.Fprintf(w, "# TYPE scanblock_scan_requests_total counter\n")
+	fmt.Fprintf(w, "scanblock_scan_requests_total %d\n", m.scanRequestsTotal.Load())
+
+	fmt.Fprintf(w, "# HELP scanblock_allow_list_hits_total Total requests that bypassed scan detection via the allow-list.\n")
+	fmt.Fprintf(w, "# TYPE scanblock_allow_list_hits_total counter\n")
+	fmt.Fprintf(w, "scanblock_allow_list_hits_total %d\n", m.allowListHitsTotal.Load())
+
+	fmt.Fprintf(w, "# HELP scanblock_blocks_issued_total Total number of times an IP was blocked.\n")
+	fmt.Fprintf(w, "# TYPE scanblock_blocks_issued_total counter\n")
+	fmt.Fprintf(w, "scanblock_blocks_issued_total %d\n", m.blocksIssuedTotal.Load())
+
+	fmt.Fprintf(w, "# HELP scanblock_ips_blocked_current Number of IPs currently blocked.\n")
+	fmt.Fprintf(w, "# TYPE scanblock_ips_blocked_current gauge\n")
+	fmt.Fprintf(w, "scanblock_ips_blocked_current %d\n", m.ipsBlockedCurrent.Load())
+
+	fmt.Fprintf(w, "# HELP scanblock_block_action_total Total responses served per block action.\n")
+	fmt.Fprintf(w, "# TYPE scanblock_block_action_total counter\n")
+	fmt.Fprintf(w, "scanblock_block_action_total{action=\"drop\"} %d\n", m.blockActionDropTotal.Load())
+	fmt.Fprintf(w, "scanblock_block_action_total{action=\"status\"} %d\n", m.blockActionStatusTotal.Load())
+	fmt.Fprintf(w, "scanblock_block_action_total{action=\"tarpit\"} %d\n", m.blockActionTarpitTotal.Load())
+	fmt.Fprintf(w, "scanblock_block_action_total{action=\"redirect\"} %d\n", m.blockActionRedirectTotal.Load())
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fmt.Fprintf(w, "# HELP scanblock_responses_total Total responses served, by status code.\n")
+	fmt.Fprintf(w, "# TYPE scanblock_responses_total counter\n")
+	for code, count := range m.statusCodesServed {
+		fmt.Fprintf(w, "scanblock_responses_total{code=\"%d\"} %d\n", code, count)
+	}
+}
+
+// ServeHTTP renders Metrics at the conventional /metrics path.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WritePrometheus(w)
+}
+
+// startMetricsServer starts a small HTTP server exposing metrics at
+// Config.MetricsAddr, shutting down when ctx is done. A no-op if addr is
+// empty.
+func startMetricsServer(ctx context.Context, addr string, metrics *Metrics) {
+	if addr == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+
+	server := &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			fmt.Printf("scanblock plugin metrics server failed: %v\n", err)
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+}
@@ -0,0 +1,275 @@
+package scanblock
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedisServer is a minimal RESP server good enough to exercise redisStore
+// against a real net.Conn, including the reconnect-on-network-error path.
+type fakeRedisServer struct {
+	ln   net.Listener
+	mu   sync.Mutex
+	data map[string]string
+	// failNextGets, if > 0, makes the next GET(s) close the connection
+	// without a reply instead of answering, to simulate a dropped link.
+	failNextGets int
+	conns        int
+}
+
+func newFakeRedisServer(t *testing.T) *fakeRedisServer {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+
+	s := &fakeRedisServer{ln: ln, data: map[string]string{}}
+	go s.serve()
+	t.Cleanup(func() { ln.Close() })
+	return s
+}
+
+func (s *fakeRedisServer) addr() string { return s.ln.Addr().String() }
+
+func (s *fakeRedisServer) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns++
+		s.mu.Unlock()
+		go s.handleConn(conn)
+	}
+}
+
+func (s *fakeRedisServer) connCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns
+}
+
+// waitForConns blocks until the server has accepted at least n connections,
+// since Accept() runs in its own goroutine and may lag a client's connect().
+func (s *fakeRedisServer) waitForConns(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if s.connCount() >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d connections, have %d", n, s.connCount())
+}
+
+func (s *fakeRedisServer) handleConn(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	for {
+		args, err := readRESPCommand(r)
+		if err != nil {
+			return
+		}
+
+		switch strings.ToUpper(args[0]) {
+		case "SET":
+			s.mu.Lock()
+			s.data[args[1]] = args[2]
+			s.mu.Unlock()
+			fmt.Fprint(conn, "+OK\r\n")
+		case "GET":
+			s.mu.Lock()
+			fail := s.failNextGets > 0
+			if fail {
+				s.failNextGets--
+			}
+			v, ok := s.data[args[1]]
+			s.mu.Unlock()
+
+			if fail {
+				return // drop the connection, simulating a stalled/dead link
+			}
+			if !ok {
+				fmt.Fprint(conn, "$-1\r\n")
+				continue
+			}
+			fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(v), v)
+		case "DEL":
+			s.mu.Lock()
+			delete(s.data, args[1])
+			s.mu.Unlock()
+			fmt.Fprint(conn, ":1\r\n")
+		case "KEYS":
+			s.mu.Lock()
+			var keys []string
+			for k := range s.data {
+				keys = append(keys, k)
+			}
+			s.mu.Unlock()
+
+			fmt.Fprintf(conn, "*%d\r\n", len(keys))
+			for _, k := range keys {
+				fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(k), k)
+			}
+		default:
+			fmt.Fprintf(conn, "-ERR unknown command %q\r\n", args[0])
+		}
+	}
+}
+
+// readRESPCommand parses one RESP array-of-bulk-strings command, the only
+// shape redisStore ever sends.
+func readRESPCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if len(line) == 0 || line[0] != '*' {
+		return nil, fmt.Errorf("unexpected command line %q", line)
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		bulkLine, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		bulkLine = strings.TrimRight(bulkLine, "\r\n")
+		ln, err := strconv.Atoi(bulkLine[1:])
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, ln+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		args = append(args, string(data[:ln]))
+	}
+
+	return args, nil
+}
+
+func TestRedisStoreGetPutDeleteList(t *testing.T) {
+	server := newFakeRedisServer(t)
+
+	rs, err := newRedisStore(server.addr(), "", 0, "scanblock:")
+	if err != nil {
+		t.Fatalf("newRedisStore: %v", err)
+	}
+
+	if record, err := rs.Get("203.0.113.1"); err != nil || record != nil {
+		t.Fatalf("Get on empty store = (%v, %v), want (nil, nil)", record, err)
+	}
+
+	want := &StoreRecord{IP: "203.0.113.1", Blocking: true}
+	if err := rs.Put("203.0.113.1", want, time.Hour); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	got, err := rs.Get("203.0.113.1")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got == nil || got.IP != want.IP || got.Blocking != want.Blocking {
+		t.Fatalf("Get = %+v, want %+v", got, want)
+	}
+
+	records, err := rs.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(records) != 1 || records[0].IP != want.IP {
+		t.Fatalf("List = %+v, want one record for %s", records, want.IP)
+	}
+
+	if err := rs.Delete("203.0.113.1"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if record, err := rs.Get("203.0.113.1"); err != nil || record != nil {
+		t.Fatalf("Get after Delete = (%v, %v), want (nil, nil)", record, err)
+	}
+}
+
+func TestRedisStoreReconnectsOnNetworkError(t *testing.T) {
+	server := newFakeRedisServer(t)
+
+	rs, err := newRedisStore(server.addr(), "", 0, "scanblock:")
+	if err != nil {
+		t.Fatalf("newRedisStore: %v", err)
+	}
+	if err := rs.Put("203.0.113.1", &StoreRecord{IP: "203.0.113.1"}, 0); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	server.waitForConns(t, 1)
+	connsBefore := server.connCount()
+
+	server.mu.Lock()
+	server.failNextGets = 1
+	server.mu.Unlock()
+
+	record, err := rs.Get("203.0.113.1")
+	if err != nil {
+		t.Fatalf("Get after a dropped connection: %v, want transparent reconnect", err)
+	}
+	if record == nil || record.IP != "203.0.113.1" {
+		t.Fatalf("Get = %+v, want the record to survive the reconnect", record)
+	}
+
+	if server.connCount() <= connsBefore {
+		t.Fatalf("server saw %d connections, want more than %d after a dropped link forced a reconnect", server.connCount(), connsBefore)
+	}
+}
+
+func TestRedisStoreProtocolErrorDoesNotReconnect(t *testing.T) {
+	server := newFakeRedisServer(t)
+
+	rs, err := newRedisStore(server.addr(), "", 0, "scanblock:")
+	if err != nil {
+		t.Fatalf("newRedisStore: %v", err)
+	}
+
+	server.waitForConns(t, 1)
+	connsBefore := server.connCount()
+
+	_, err = rs.do("BOGUSCOMMAND")
+	if err == nil {
+		t.Fatalf("do(BOGUSCOMMAND) succeeded, want a RESP-level error")
+	}
+	if isNetworkError(err) {
+		t.Fatalf("isNetworkError(%v) = true, want false for a RESP error reply", err)
+	}
+	if server.connCount() != connsBefore {
+		t.Fatalf("server saw %d connections, want %d (no reconnect for a protocol error)", server.connCount(), connsBefore)
+	}
+}
+
+func TestReadArrayReplyNilMultiBulk(t *testing.T) {
+	rs := &redisStore{reader: bufio.NewReader(strings.NewReader("*-1\r\n"))}
+
+	items, err := rs.readArrayReply()
+	if err != nil {
+		t.Fatalf("readArrayReply: %v", err)
+	}
+	if items != nil {
+		t.Fatalf("readArrayReply = %v, want nil for a nil multi-bulk reply", items)
+	}
+}
@@ -0,0 +1,45 @@
+package scanblock
+
+import "net/http"
+
+// ResponseWriter wraps http.ResponseWriter to record the response status
+// code against the client's CacheEntry (4xx responses count as scan
+// activity) and, if configured, against Metrics.
+type ResponseWriter struct {
+	http.ResponseWriter
+
+	cacheEntry *CacheEntry
+	metrics    *Metrics
+
+	wroteHeader bool
+}
+
+// WriteHeader records statusCode before delegating to the wrapped writer.
+func (rw *ResponseWriter) WriteHeader(statusCode int) {
+	if rw.wroteHeader {
+		rw.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+	rw.wroteHeader = true
+
+	if statusCode >= 400 && statusCode < 500 {
+		rw.cacheEntry.ScanRequests.Add(1)
+		if rw.metrics != nil {
+			rw.metrics.RecordScanRequest()
+		}
+	}
+	if rw.metrics != nil {
+		rw.metrics.RecordStatusCode(statusCode)
+	}
+
+	rw.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write implicitly sends a 200 OK, like the standard library's
+// http.ResponseWriter, so status recording still happens.
+func (rw *ResponseWriter) Write(b []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	return rw.ResponseWriter.Write(b)
+}
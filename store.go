@@ -0,0 +1,556 @@
+package scanblock
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StoreRecord is the serializable snapshot of a CacheEntry persisted to a
+// Store. CacheEntry itself is not serializable directly since its fields are
+// atomics and its counters are SlidingCounters with unexported state.
+type StoreRecord struct {
+	IP            string `json:"ip"`
+	FirstSeen     int64  `json:"firstSeen"`
+	LastSeen      int64  `json:"lastSeen"`
+	TotalRequests uint64 `json:"totalRequests"`
+	ScanRequests  uint64 `json:"scanRequests"`
+	Blocking      bool   `json:"blocking"`
+}
+
+// Store persists block decisions so they survive a restart and can be shared
+// across multiple plugin instances.
+type Store interface {
+	// Get returns the record for ip, or nil if it isn't known to the store.
+	Get(ip string) (*StoreRecord, error)
+	// Put upserts the record for ip, expiring it after ttl.
+	Put(ip string, record *StoreRecord, ttl time.Duration) error
+	// Delete removes the record for ip, if any.
+	Delete(ip string) error
+	// List returns every record currently known to the store, used to warm
+	// the in-memory cache on startup.
+	List() ([]*StoreRecord, error)
+}
+
+// newStore builds the Store configured by config.Store*, or nil if no
+// backend is configured.
+func newStore(ctx context.Context, config *Config) (Store, error) {
+	switch strings.ToLower(config.StoreType) {
+	case "":
+		return nil, nil
+	case "file":
+		fs, err := newFileStore(config.StoreFile, config.StoreFileRotateCount)
+		if err != nil {
+			return nil, err
+		}
+		startFileStoreFlusher(ctx, fs, time.Duration(config.StoreFileFlushSeconds)*time.Second)
+		return fs, nil
+	case "redis":
+		return newRedisStore(config.RedisAddr, config.RedisPassword, config.RedisDB, config.RedisKeyPrefix)
+	default:
+		return nil, fmt.Errorf("unknown store type %q", config.StoreType)
+	}
+}
+
+// fileStore persists records as a gzipped JSON snapshot, rotating the
+// previous snapshot the way AdGuardHome rotates its query log. Put/Delete
+// only update the in-memory map; flushes to disk are batched by
+// startFileStoreFlusher so a flood of distinct IPs doesn't turn into a
+// full-snapshot rewrite and rename chain per request.
+type fileStore struct {
+	mu          sync.Mutex
+	path        string
+	rotateCount int
+	records     map[string]*StoreRecord
+	expiresAt   map[string]time.Time
+	dirty       bool
+}
+
+func newFileStore(path string, rotateCount int) (*fileStore, error) {
+	if path == "" {
+		return nil, fmt.Errorf("scanblock: storeFile must be set for the file store")
+	}
+	if rotateCount <= 0 {
+		rotateCount = 3
+	}
+
+	fs := &fileStore{
+		path:        path,
+		rotateCount: rotateCount,
+		records:     map[string]*StoreRecord{},
+		expiresAt:   map[string]time.Time{},
+	}
+
+	if err := fs.load(); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("scanblock: failed to load store file %s: %w", path, err)
+	}
+
+	return fs, nil
+}
+
+// startFileStoreFlusher periodically flushes fs to disk if it has pending
+// writes, and sweeps out records whose ttl has elapsed. It exits when ctx is
+// done, flushing once more first so the last few writes aren't lost.
+func startFileStoreFlusher(ctx context.Context, fs *fileStore, interval time.Duration) {
+	if interval <= 0 {
+		interval = time.Duration(DefaultStoreFileFlushSeconds) * time.Second
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				if err := fs.flushIfDirty(); err != nil {
+					fmt.Fprintf(os.Stderr, "scanblock plugin failed to flush store file %s: %v\n", fs.path, err)
+				}
+				return
+			case <-ticker.C:
+				fs.expireRecords()
+				if err := fs.flushIfDirty(); err != nil {
+					fmt.Fprintf(os.Stderr, "scanblock plugin failed to flush store file %s: %v\n", fs.path, err)
+				}
+			}
+		}
+	}()
+}
+
+func (fs *fileStore) load() error {
+	f, err := os.Open(fs.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	var records []*StoreRecord
+	if err := json.NewDecoder(gz).Decode(&records); err != nil {
+		return err
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for _, record := range records {
+		fs.records[record.IP] = record
+	}
+
+	return nil
+}
+
+func (fs *fileStore) Get(ip string) (*StoreRecord, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.records[ip], nil
+}
+
+func (fs *fileStore) Put(ip string, record *StoreRecord, ttl time.Duration) error {
+	fs.mu.Lock()
+	fs.records[ip] = record
+	if ttl > 0 {
+		fs.expiresAt[ip] = time.Now().Add(ttl)
+	} else {
+		delete(fs.expiresAt, ip)
+	}
+	fs.dirty = true
+	fs.mu.Unlock()
+	return nil
+}
+
+func (fs *fileStore) Delete(ip string) error {
+	fs.mu.Lock()
+	delete(fs.records, ip)
+	delete(fs.expiresAt, ip)
+	fs.dirty = true
+	fs.mu.Unlock()
+	return nil
+}
+
+// expireRecords drops every record whose ttl has elapsed since it was Put.
+func (fs *fileStore) expireRecords() {
+	now := time.Now()
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for ip, expiresAt := range fs.expiresAt {
+		if now.After(expiresAt) {
+			delete(fs.records, ip)
+			delete(fs.expiresAt, ip)
+			fs.dirty = true
+		}
+	}
+}
+
+// flushIfDirty flushes to disk only if records have changed since the last
+// flush, so an idle store doesn't rewrite the snapshot every tick.
+func (fs *fileStore) flushIfDirty() error {
+	fs.mu.Lock()
+	dirty := fs.dirty
+	fs.dirty = false
+	fs.mu.Unlock()
+
+	if !dirty {
+		return nil
+	}
+	return fs.flush()
+}
+
+func (fs *fileStore) List() ([]*StoreRecord, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	records := make([]*StoreRecord, 0, len(fs.records))
+	for _, record := range fs.records {
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// flush rotates the previous snapshot and writes the current records to
+// disk, gzip-compressed.
+func (fs *fileStore) flush() error {
+	fs.mu.Lock()
+	records := make([]*StoreRecord, 0, len(fs.records))
+	for _, record := range fs.records {
+		records = append(records, record)
+	}
+	fs.mu.Unlock()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(gz).Encode(records); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	fs.rotate()
+
+	return os.WriteFile(fs.path, buf.Bytes(), 0o600)
+}
+
+// rotate shifts store.path.N.gz -> store.path.N+1.gz up to rotateCount,
+// dropping the oldest, then moves the current file to store.path.1.gz.
+func (fs *fileStore) rotate() {
+	if _, err := os.Stat(fs.path); err != nil {
+		return
+	}
+
+	oldest := fmt.Sprintf("%s.%d.gz", fs.path, fs.rotateCount)
+	os.Remove(oldest)
+
+	for n := fs.rotateCount - 1; n >= 1; n-- {
+		os.Rename(fmt.Sprintf("%s.%d.gz", fs.path, n), fmt.Sprintf("%s.%d.gz", fs.path, n+1))
+	}
+
+	os.Rename(fs.path, fs.path+".1.gz")
+}
+
+// redisDialTimeout bounds the initial TCP connect. redisIOTimeout bounds
+// every subsequent command, so a Redis instance that accepts the connection
+// and then stalls (overload, a firewall black-holing the link) can't hang a
+// caller forever.
+const (
+	redisDialTimeout = 5 * time.Second
+	redisIOTimeout   = 5 * time.Second
+)
+
+// redisProtocolError marks a RESP-level error reply (e.g. a bad command).
+// It's distinguished from network errors so do/doArray only reconnect on the
+// latter, not on a Redis-side rejection that a fresh connection can't fix.
+type redisProtocolError struct {
+	msg string
+}
+
+func (e *redisProtocolError) Error() string { return e.msg }
+
+// redisStore persists records as Redis strings (JSON-encoded) under a
+// configurable key prefix, using a hand-rolled RESP client since the plugin
+// can only depend on the standard library.
+type redisStore struct {
+	mu        sync.Mutex
+	conn      net.Conn
+	reader    *bufio.Reader
+	addr      string
+	password  string
+	db        int
+	keyPrefix string
+}
+
+func newRedisStore(addr, password string, db int, keyPrefix string) (*redisStore, error) {
+	if addr == "" {
+		return nil, fmt.Errorf("scanblock: redisAddr must be set for the redis store")
+	}
+	if keyPrefix == "" {
+		keyPrefix = "scanblock:"
+	}
+
+	rs := &redisStore{
+		addr:      addr,
+		password:  password,
+		db:        db,
+		keyPrefix: keyPrefix,
+	}
+
+	if err := rs.connect(); err != nil {
+		return nil, err
+	}
+
+	return rs, nil
+}
+
+func (rs *redisStore) connect() error {
+	conn, err := net.DialTimeout("tcp", rs.addr, redisDialTimeout)
+	if err != nil {
+		return fmt.Errorf("scanblock: failed to connect to redis at %s: %w", rs.addr, err)
+	}
+	rs.conn = conn
+	rs.reader = bufio.NewReader(conn)
+
+	if rs.password != "" {
+		if _, err := rs.doOnce("AUTH", rs.password); err != nil {
+			rs.conn.Close()
+			return err
+		}
+	}
+	if rs.db != 0 {
+		if _, err := rs.doOnce("SELECT", strconv.Itoa(rs.db)); err != nil {
+			rs.conn.Close()
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reconnect replaces a dead connection with a fresh one. Callers must hold
+// rs.mu.
+func (rs *redisStore) reconnect() error {
+	if rs.conn != nil {
+		rs.conn.Close()
+	}
+	return rs.connect()
+}
+
+func (rs *redisStore) Get(ip string) (*StoreRecord, error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	reply, err := rs.do("GET", rs.keyPrefix+ip)
+	if err != nil {
+		return nil, err
+	}
+	if reply == "" {
+		return nil, nil
+	}
+
+	var record StoreRecord
+	if err := json.Unmarshal([]byte(reply), &record); err != nil {
+		return nil, fmt.Errorf("scanblock: failed to decode redis record for %s: %w", ip, err)
+	}
+
+	return &record, nil
+}
+
+func (rs *redisStore) Put(ip string, record *StoreRecord, ttl time.Duration) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	seconds := int(ttl.Seconds())
+	if seconds <= 0 {
+		_, err = rs.do("SET", rs.keyPrefix+ip, string(data))
+	} else {
+		_, err = rs.do("SET", rs.keyPrefix+ip, string(data), "EX", strconv.Itoa(seconds))
+	}
+	return err
+}
+
+func (rs *redisStore) Delete(ip string) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	_, err := rs.do("DEL", rs.keyPrefix+ip)
+	return err
+}
+
+func (rs *redisStore) List() ([]*StoreRecord, error) {
+	rs.mu.Lock()
+	keysReply, err := rs.doArray("KEYS", rs.keyPrefix+"*")
+	rs.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]*StoreRecord, 0, len(keysReply))
+	for _, key := range keysReply {
+		ip := strings.TrimPrefix(key, rs.keyPrefix)
+		record, err := rs.Get(ip)
+		if err != nil {
+			return nil, err
+		}
+		if record != nil {
+			records = append(records, record)
+		}
+	}
+
+	return records, nil
+}
+
+// do sends a RESP command and returns a single bulk/simple string reply.
+// Callers must hold rs.mu. A network error (connection dropped, stalled
+// Redis past the deadline) triggers one reconnect-and-retry; a RESP-level
+// error reply does not, since reconnecting wouldn't change it.
+func (rs *redisStore) do(args ...string) (string, error) {
+	reply, err := rs.doOnce(args[0], args[1:]...)
+	if isNetworkError(err) {
+		if rerr := rs.reconnect(); rerr != nil {
+			return "", fmt.Errorf("scanblock: redis connection lost and reconnect failed: %v (original error: %w)", rerr, err)
+		}
+		reply, err = rs.doOnce(args[0], args[1:]...)
+	}
+	return reply, err
+}
+
+// doArray sends a RESP command and returns a multi-bulk reply, with the same
+// reconnect-on-network-error behavior as do.
+func (rs *redisStore) doArray(args ...string) ([]string, error) {
+	reply, err := rs.doArrayOnce(args)
+	if isNetworkError(err) {
+		if rerr := rs.reconnect(); rerr != nil {
+			return nil, fmt.Errorf("scanblock: redis connection lost and reconnect failed: %v (original error: %w)", rerr, err)
+		}
+		reply, err = rs.doArrayOnce(args)
+	}
+	return reply, err
+}
+
+func (rs *redisStore) doOnce(cmd string, args ...string) (string, error) {
+	if err := rs.writeCommand(append([]string{cmd}, args...)); err != nil {
+		return "", err
+	}
+	return rs.readReply()
+}
+
+func (rs *redisStore) doArrayOnce(args []string) ([]string, error) {
+	if err := rs.writeCommand(args); err != nil {
+		return nil, err
+	}
+	return rs.readArrayReply()
+}
+
+// isNetworkError reports whether err came from the connection itself
+// (closed, reset, timed out) rather than a RESP-level error reply.
+func isNetworkError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var protoErr *redisProtocolError
+	return !errors.As(err, &protoErr)
+}
+
+func (rs *redisStore) writeCommand(args []string) error {
+	if err := rs.conn.SetDeadline(time.Now().Add(redisIOTimeout)); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&buf, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_, err := rs.conn.Write(buf.Bytes())
+	return err
+}
+
+func (rs *redisStore) readReply() (string, error) {
+	line, err := rs.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+
+	switch line[0] {
+	case '-':
+		return "", &redisProtocolError{msg: fmt.Sprintf("scanblock: redis error: %s", line[1:])}
+	case '+':
+		return line[1:], nil
+	case ':':
+		return line[1:], nil
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return "", err
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(rs.reader, data); err != nil {
+			return "", err
+		}
+		return string(data[:n]), nil
+	default:
+		return "", fmt.Errorf("scanblock: unexpected redis reply %q", line)
+	}
+}
+
+func (rs *redisStore) readArrayReply() ([]string, error) {
+	line, err := rs.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line[0] != '*' {
+		return nil, fmt.Errorf("scanblock: unexpected redis reply %q", line)
+	}
+
+	count, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, err
+	}
+	if count < 0 {
+		// A nil multi-bulk reply (*-1\r\n) means "no value", not an empty
+		// array; treat it as such rather than panicking on a negative cap.
+		return nil, nil
+	}
+
+	items := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		bulkLine, err := rs.reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		bulkLine = strings.TrimRight(bulkLine, "\r\n")
+		n, err := strconv.Atoi(bulkLine[1:])
+		if err != nil {
+			return nil, err
+		}
+
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(rs.reader, data); err != nil {
+			return nil, err
+		}
+		items = append(items, string(data[:n]))
+	}
+
+	return items, nil
+}
@@ -0,0 +1,52 @@
+package scanblock
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStorePutDoesNotFlushSynchronously(t *testing.T) {
+	fs, err := newFileStore(filepath.Join(t.TempDir(), "store.gz"), 0)
+	if err != nil {
+		t.Fatalf("newFileStore: %v", err)
+	}
+
+	if err := fs.Put("203.0.113.1", &StoreRecord{IP: "203.0.113.1"}, time.Hour); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	if _, err := os.Stat(fs.path); err == nil {
+		t.Fatalf("Put flushed to disk synchronously; expected it to wait for a periodic flush")
+	}
+
+	if err := fs.flushIfDirty(); err != nil {
+		t.Fatalf("flushIfDirty: %v", err)
+	}
+	if _, err := os.Stat(fs.path); err != nil {
+		t.Fatalf("expected %s to exist after flushIfDirty: %v", fs.path, err)
+	}
+}
+
+func TestFileStoreExpireRecords(t *testing.T) {
+	fs, err := newFileStore(filepath.Join(t.TempDir(), "store.gz"), 0)
+	if err != nil {
+		t.Fatalf("newFileStore: %v", err)
+	}
+
+	if err := fs.Put("203.0.113.1", &StoreRecord{IP: "203.0.113.1"}, time.Millisecond); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	fs.expireRecords()
+
+	record, err := fs.Get("203.0.113.1")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if record != nil {
+		t.Fatalf("Get returned %+v after ttl elapsed, want nil", record)
+	}
+}